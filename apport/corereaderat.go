@@ -0,0 +1,380 @@
+// Package apport provides random-access readers over apport problem
+// report fields, so callers such as an ELF note reader or a debugger can
+// pull specific byte ranges out of a multi-gigabyte core dump without
+// extracting the whole field to disk first.
+//
+// For an uncompressed ("raw") CoreDump field, ReadAt is true random
+// access: the base64 line index built by index() already lets
+// fieldReader resume decoding at an arbitrary decoded offset, and there
+// is nothing to decompress, so readAtRaw reads straight off disk with no
+// materialization step at all.
+//
+// For a compressed field (gzip, zlib, bzip2, zstd), ReadAt is random
+// access only in the sense that it never requires the caller to extract
+// CoreDump.core to disk first. A true access-point index - periodic
+// checkpoints of decompressor state letting ReadAt jump straight to an
+// arbitrary window - is out of scope here: building one needs the
+// decompressor to expose a bit-level position and the ability to resume
+// mid-stream from it (the zran.c technique), and neither compress/flate
+// nor compress/bzip2 nor klauspost/compress/zstd expose that through
+// their public APIs. Byte-counting a "checkpoint" without bit alignment
+// would decode garbage, not a shortcut. So fillSpillTo instead
+// decompresses forward into a spill file once; a ReadAt past the current
+// spill point still has to decompress (continuing, not restarting, the
+// live r.dz) through everything in between to get there, but later reads
+// of already-spilled bytes are cheap regardless of format.
+package apport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/a-palchikov/apport-core-unpacker/report"
+)
+
+// spillThreshold is the decompressed field size above which CoreReaderAt
+// decompresses lazily into a spill file instead of eagerly into memory.
+// This has to be checked against decompressed size, not the size of the
+// base64+compressed field on disk: apport cores routinely compress
+// 10-20x, so a field well under this threshold on disk can still expand
+// to several GB once decompressed.
+const spillThreshold = 64 << 20 // 64 MiB
+
+// lineOffset records, for one CoreDump continuation line, the offset of
+// its leading space in the report file and the offset of its first byte
+// within the field once base64-decoded (but not yet decompressed).
+type lineOffset struct {
+	fileOffset    int64
+	decodedOffset int64
+}
+
+// CoreReaderAt is an io.ReaderAt over the decoded, decompressed bytes of
+// an apport report's CoreDump field. Building one scans the report once
+// to index the file offset of every CoreDump continuation line, which is
+// cheap. For the "raw" format that index is enough for ReadAt to be true
+// random access on its own (see readAtRaw); for compressed formats the
+// decompressed bytes are materialized as ReadAt calls need them, via
+// fillSpillTo (see the package doc for why that can't do better).
+type CoreReaderAt struct {
+	f        *os.File
+	lines    []lineOffset
+	fieldEnd int64
+	format   string
+
+	mu       sync.Mutex
+	small    []byte   // the whole field, decompressed eagerly, for fields that decompress to below spillThreshold
+	spill    *os.File // decompressed bytes materialized so far, for fields that decompress to at or above spillThreshold
+	spillLen int64
+	dz       io.ReadCloser // live decompressor feeding spill, positioned at spillLen
+}
+
+// NewCoreReaderAt scans f for the report's CoreDump field and returns a
+// CoreReaderAt over its decoded, decompressed bytes. f must be seekable;
+// NewCoreReaderAt leaves its read offset unspecified on return.
+func NewCoreReaderAt(f *os.File) (*CoreReaderAt, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	r := &CoreReaderAt{f: f}
+	if err := r.index(); err != nil {
+		return nil, err
+	}
+	if len(r.lines) == 0 {
+		return nil, fmt.Errorf("apport: no CoreDump field found")
+	}
+
+	fr, err := r.fieldReader(0)
+	if err != nil {
+		return nil, err
+	}
+	defer fr.Close()
+
+	format, _, err := report.DetectCompression(fr)
+	if err != nil {
+		return nil, fmt.Errorf("apport: detecting compression: %w", err)
+	}
+	r.format = format
+
+	// A raw field needs no decompression, so readAtRaw serves ReadAt
+	// directly from the base64 line index: no eager read, no spill file.
+	if r.format != "raw" {
+		if err := r.readSmall(); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// Format reports the compression format detected for the CoreDump field,
+// e.g. "gzip", "zstd", "bzip2", "zlib" or "raw".
+func (r *CoreReaderAt) Format() string {
+	return r.format
+}
+
+// index performs a single linear scan of the report file, recording the
+// file offset of every CoreDump continuation line and where fieldEnd
+// falls once the field ends.
+func (r *CoreReaderAt) index() error {
+	rdr := bufio.NewReader(r.f)
+
+	var offset, decoded int64
+	inField := false
+
+	for {
+		line, rerr := rdr.ReadBytes('\n')
+
+		if inField {
+			if len(line) == 0 || line[0] != ' ' {
+				r.fieldEnd = offset
+				return nil
+			}
+			r.lines = append(r.lines, lineOffset{fileOffset: offset, decodedOffset: decoded})
+			encodedLen := len(bytes.TrimSuffix(line[1:], []byte("\n")))
+			decoded += int64(base64.StdEncoding.DecodedLen(encodedLen))
+		} else if bytes.HasPrefix(line, []byte("CoreDump:")) {
+			inField = true
+		}
+
+		offset += int64(len(line))
+		if rerr != nil {
+			if inField {
+				r.fieldEnd = offset
+			}
+			return nil
+		}
+	}
+}
+
+// fieldReader returns the field's base64-decoded (but still compressed)
+// bytes, starting at decodedOffset. It seeks to the nearest indexed line
+// at or before decodedOffset and discards the handful of bytes between
+// that line and decodedOffset, so callers never need to decode the whole
+// field just to resume partway through it.
+func (r *CoreReaderAt) fieldReader(decodedOffset int64) (io.ReadCloser, error) {
+	i := sort.Search(len(r.lines), func(i int) bool {
+		return r.lines[i].decodedOffset > decodedOffset
+	}) - 1
+	if i < 0 {
+		i = 0
+	}
+
+	sr := io.NewSectionReader(r.f, r.lines[i].fileOffset, r.fieldEnd-r.lines[i].fileOffset)
+	dec := base64.NewDecoder(base64.StdEncoding, &stripSpaceReader{r: bufio.NewReader(sr)})
+
+	if skip := decodedOffset - r.lines[i].decodedOffset; skip > 0 {
+		if _, err := io.CopyN(io.Discard, dec, skip); err != nil {
+			return nil, err
+		}
+	}
+	return io.NopCloser(dec), nil
+}
+
+// decompressor opens a fresh, decompressing reader over the field
+// starting at decodedOffset bytes into its base64-decoded form.
+func (r *CoreReaderAt) decompressor(decodedOffset int64) (io.ReadCloser, error) {
+	fr, err := r.fieldReader(decodedOffset)
+	if err != nil {
+		return nil, err
+	}
+	dz, err := report.NewFieldReader(fr)
+	if err != nil {
+		fr.Close()
+		return nil, err
+	}
+	return dz, nil
+}
+
+// readSmall decompresses up to spillThreshold+1 bytes of the field into
+// memory, keeping the result as r.small only if the field turned out to
+// decompress to no more than spillThreshold bytes. Anything larger is
+// left for ReadAt/fillSpillTo to materialize lazily into a spill file
+// instead, so a highly-compressed field can never balloon to several GB
+// in memory just because its on-disk, still-compressed size looked
+// small.
+func (r *CoreReaderAt) readSmall() error {
+	dz, err := r.decompressor(0)
+	if err != nil {
+		return err
+	}
+	defer dz.Close()
+
+	small, err := io.ReadAll(io.LimitReader(dz, spillThreshold+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(small)) <= spillThreshold {
+		r.small = small
+	}
+	return nil
+}
+
+// ReadAt implements io.ReaderAt over the core's decoded, decompressed
+// bytes.
+func (r *CoreReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if r.format == "raw" {
+		return r.readAtRaw(p, off)
+	}
+
+	if r.small != nil {
+		if off >= int64(len(r.small)) {
+			return 0, io.EOF
+		}
+		n := copy(p, r.small[off:])
+		if n < len(p) {
+			return n, io.EOF
+		}
+		return n, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	fillErr := r.fillSpillTo(off + int64(len(p)))
+	n, err := r.spill.ReadAt(p, off)
+	if err == io.EOF && fillErr != io.EOF {
+		err = fillErr
+	}
+	return n, err
+}
+
+// readAtRaw implements ReadAt for the "raw" format. There is no
+// decompressor to run forward: fieldReader already resumes at an
+// arbitrary decoded offset via the line index built by index(), so every
+// call reads exactly the requested window straight off disk, with no
+// spill file and no bytes decoded that the caller didn't ask for.
+func (r *CoreReaderAt) readAtRaw(p []byte, off int64) (int, error) {
+	fr, err := r.fieldReader(off)
+	if err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, io.EOF
+		}
+		return 0, err
+	}
+	defer fr.Close()
+
+	n, err := io.ReadFull(fr, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// fillSpillTo decompresses the field forward, if needed, until at least
+// need bytes have been written to the spill file. The spill file means
+// every byte is decompressed at most once no matter how many times
+// ReadAt later re-reads it, but the first ReadAt past the current spill
+// point still decompresses everything in between: there is no gzip
+// access-point index recording flate state at checkpoints that would let
+// this jump straight to need instead of walking forward from spillLen.
+func (r *CoreReaderAt) fillSpillTo(need int64) error {
+	if r.spill == nil {
+		spill, err := os.CreateTemp("", "apport-core-*.spill")
+		if err != nil {
+			return err
+		}
+		dz, err := r.decompressor(0)
+		if err != nil {
+			spill.Close()
+			os.Remove(spill.Name())
+			return err
+		}
+		r.spill = spill
+		r.dz = dz
+	}
+
+	for r.spillLen < need {
+		n, err := io.CopyN(r.spill, r.dz, 1<<20)
+		r.spillLen += n
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close releases resources held by r, including its spill file, if any.
+// It does not close the *os.File passed to NewCoreReaderAt.
+func (r *CoreReaderAt) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.dz != nil {
+		r.dz.Close()
+	}
+	if r.spill != nil {
+		name := r.spill.Name()
+		err := r.spill.Close()
+		if rerr := os.Remove(name); err == nil {
+			err = rerr
+		}
+		return err
+	}
+	return nil
+}
+
+// Reader returns an io.ReadSeeker over the same bytes as ReadAt, sharing
+// r's cache. SeekEnd is not supported, since the core's decompressed
+// size is unknown without decompressing it in full.
+func (r *CoreReaderAt) Reader() io.ReadSeeker {
+	return &coreReader{cra: r}
+}
+
+// coreReader adapts CoreReaderAt.ReadAt to an io.ReadSeeker by tracking
+// the current offset itself.
+type coreReader struct {
+	cra *CoreReaderAt
+	off int64
+}
+
+func (c *coreReader) Read(b []byte) (int, error) {
+	n, err := c.cra.ReadAt(b, c.off)
+	c.off += int64(n)
+	return n, err
+}
+
+func (c *coreReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		c.off = offset
+	case io.SeekCurrent:
+		c.off += offset
+	default:
+		return 0, fmt.Errorf("apport: unsupported whence %d", whence)
+	}
+	return c.off, nil
+}
+
+// stripSpaceReader strips the single leading space from each line read
+// from r, exposing just the base64 payload underneath.
+type stripSpaceReader struct {
+	r    *bufio.Reader
+	data []byte
+}
+
+func (r *stripSpaceReader) Read(b []byte) (n int, err error) {
+	if len(r.data) > 0 {
+		n = copy(b, r.data)
+		r.data = r.data[n:]
+		return n, nil
+	}
+
+	line, err := r.r.ReadBytes('\n')
+	if len(line) > 0 && line[0] == ' ' {
+		r.data = line[1:]
+	}
+	if len(r.data) == 0 {
+		return 0, err
+	}
+
+	n = copy(b, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
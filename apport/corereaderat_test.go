@@ -0,0 +1,223 @@
+package apport
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+)
+
+// writeRawReport writes a minimal apport report whose CoreDump field holds
+// payload base64-encoded but otherwise uncompressed, so CoreReaderAt detects
+// it as format "raw". This lets tests exercise fieldReader at a nonzero
+// decodedOffset without also having to resume a compressed stream mid-way,
+// which isn't valid regardless of offset units.
+func writeRawReport(t *testing.T, payload []byte) *os.File {
+	t.Helper()
+
+	f, err := os.CreateTemp("", "apport-core-raw-*.report")
+	if err != nil {
+		t.Fatalf("CreateTemp: %v", err)
+	}
+	t.Cleanup(func() { os.Remove(f.Name()) })
+
+	if _, err := fmt.Fprintln(f, "CoreDump:"); err != nil {
+		t.Fatalf("writing header: %v", err)
+	}
+
+	lw := &lineWrapWriter{w: f, width: lineWidth}
+	b64 := base64.NewEncoder(base64.StdEncoding, lw)
+	if _, err := b64.Write(payload); err != nil {
+		t.Fatalf("writing payload: %v", err)
+	}
+	if err := b64.Close(); err != nil {
+		t.Fatalf("closing b64 encoder: %v", err)
+	}
+	if err := lw.Flush(); err != nil {
+		t.Fatalf("flushing line writer: %v", err)
+	}
+
+	return f
+}
+
+func newCoreDumpReport(t *testing.T, core []byte, level int) *os.File {
+	t.Helper()
+
+	f, err := os.CreateTemp("", "apport-core-*.report")
+	if err != nil {
+		t.Fatalf("CreateTemp: %v", err)
+	}
+	t.Cleanup(func() { os.Remove(f.Name()) })
+
+	if err := NewWriter(f, level).WriteReport(nil, bytes.NewReader(core)); err != nil {
+		t.Fatalf("WriteReport: %v", err)
+	}
+	return f
+}
+
+func TestCoreReaderAtSpillsLargeDecompressedField(t *testing.T) {
+	core := make([]byte, spillThreshold+1<<20) // decompresses to just over spillThreshold, but all-zero so it compresses to a tiny on-disk size
+
+	f := newCoreDumpReport(t, core, 9)
+
+	cra, err := NewCoreReaderAt(f)
+	if err != nil {
+		t.Fatalf("NewCoreReaderAt: %v", err)
+	}
+	defer cra.Close()
+
+	if cra.small != nil {
+		t.Fatalf("field decompresses to %d bytes (> spillThreshold of %d) but was read eagerly into memory", len(core), spillThreshold)
+	}
+
+	got := make([]byte, len(core))
+	if _, err := io.ReadFull(io.NewSectionReader(cra, 0, int64(len(core))), got); err != nil {
+		t.Fatalf("ReadAt: %v", err)
+	}
+	if !bytes.Equal(got, core) {
+		t.Fatal("spilled bytes differ from the original core")
+	}
+}
+
+func TestCoreReaderAtReadAtTailWindowMatchesFullDecode(t *testing.T) {
+	core := bytes.Repeat([]byte{0x10, 0x20, 0x30, 0x40, 0x50}, 500000)
+
+	f := newCoreDumpReport(t, core, 6)
+
+	cra, err := NewCoreReaderAt(f)
+	if err != nil {
+		t.Fatalf("NewCoreReaderAt: %v", err)
+	}
+	defer cra.Close()
+
+	const windowLen = 4096
+	off := int64(len(core) - windowLen)
+
+	got := make([]byte, windowLen)
+	if _, err := cra.ReadAt(got, off); err != nil {
+		t.Fatalf("ReadAt: %v", err)
+	}
+	if !bytes.Equal(got, core[off:]) {
+		t.Fatal("tail window read via ReadAt differs from the same bytes in a full decode")
+	}
+}
+
+func TestCoreReaderAtReaderSeekMatchesFullDecode(t *testing.T) {
+	core := bytes.Repeat([]byte("hello world, this is a core dump\n"), 10000)
+
+	f := newCoreDumpReport(t, core, 4)
+
+	cra, err := NewCoreReaderAt(f)
+	if err != nil {
+		t.Fatalf("NewCoreReaderAt: %v", err)
+	}
+	defer cra.Close()
+
+	r := cra.Reader()
+	off := int64(len(core) / 3)
+	if _, err := r.Seek(off, io.SeekStart); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+
+	got := make([]byte, 1024)
+	if _, err := io.ReadFull(r, got); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if !bytes.Equal(got, core[off:off+1024]) {
+		t.Fatal("bytes read after Seek differ from the same bytes in a full decode")
+	}
+}
+
+// TestCoreReaderAtRawReadAtSkipsMaterialization is a regression test for
+// readAtRaw: a ReadAt into a "raw" field, even one far larger than
+// spillThreshold, must not fall back to fillSpillTo's spill file or
+// readSmall's eager in-memory read - it should resolve straight off the
+// line index instead.
+func TestCoreReaderAtRawReadAtSkipsMaterialization(t *testing.T) {
+	payload := bytes.Repeat([]byte("raw core bytes, no compression here\n"), 3_000_000) // well over spillThreshold
+	if int64(len(payload)) <= spillThreshold {
+		t.Fatalf("test payload of %d bytes is not larger than spillThreshold of %d", len(payload), spillThreshold)
+	}
+
+	f := writeRawReport(t, payload)
+
+	cra, err := NewCoreReaderAt(f)
+	if err != nil {
+		t.Fatalf("NewCoreReaderAt: %v", err)
+	}
+	defer cra.Close()
+
+	if cra.Format() != "raw" {
+		t.Fatalf("Format() = %q, want %q", cra.Format(), "raw")
+	}
+	if cra.small != nil {
+		t.Fatal("NewCoreReaderAt eagerly materialized a raw field into memory")
+	}
+
+	off := int64(len(payload) - 4096)
+	got := make([]byte, 4096)
+	if _, err := cra.ReadAt(got, off); err != nil {
+		t.Fatalf("ReadAt: %v", err)
+	}
+	if !bytes.Equal(got, payload[off:]) {
+		t.Fatal("tail window read via ReadAt differs from the original payload")
+	}
+
+	if cra.spill != nil {
+		t.Fatal("ReadAt on a raw field materialized a spill file instead of reading the line index directly")
+	}
+}
+
+func TestNewCoreReaderAtNoCoreDumpField(t *testing.T) {
+	f, err := os.CreateTemp("", "apport-core-empty-*.report")
+	if err != nil {
+		t.Fatalf("CreateTemp: %v", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := fmt.Fprintln(f, "ProblemType: Crash"); err != nil {
+		t.Fatalf("writing report: %v", err)
+	}
+
+	if _, err := NewCoreReaderAt(f); err == nil {
+		t.Fatal("expected an error for a report with no CoreDump field")
+	}
+}
+
+// TestFieldReaderDecodedOffsetUnits is a white-box regression test for the
+// index()/fieldReader decodedOffset units bug: decodedOffset must be
+// measured in decoded bytes, not base64-encoded characters. It uses a raw
+// (uncompressed) field so that resuming at a nonzero offset is itself
+// meaningful, independent of any compression format.
+func TestFieldReaderDecodedOffsetUnits(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 1000)
+
+	f := writeRawReport(t, payload)
+
+	cra, err := NewCoreReaderAt(f)
+	if err != nil {
+		t.Fatalf("NewCoreReaderAt: %v", err)
+	}
+	defer cra.Close()
+
+	if cra.Format() != "raw" {
+		t.Fatalf("Format() = %q, want %q", cra.Format(), "raw")
+	}
+
+	off := int64(len(payload) / 2)
+	fr, err := cra.fieldReader(off)
+	if err != nil {
+		t.Fatalf("fieldReader: %v", err)
+	}
+	defer fr.Close()
+
+	got, err := io.ReadAll(fr)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(got, payload[off:]) {
+		t.Fatalf("fieldReader(%d) returned %d bytes not matching payload[%d:]; decodedOffset units are likely wrong", off, len(got), off)
+	}
+}
@@ -0,0 +1,124 @@
+package apport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/a-palchikov/apport-core-unpacker/report"
+)
+
+// sequentialDecode decodes field, the base64-encoded, space-prefixed
+// continuation lines of a report field, the same way CoreReaderAt does:
+// strip space, base64-decode and decompress in a single goroutine. It
+// serves as the equivalence baseline for NewParallelReader.
+func sequentialDecode(t *testing.T, field []byte) []byte {
+	t.Helper()
+
+	dec := base64.NewDecoder(base64.StdEncoding, &stripSpaceReader{r: bufio.NewReader(bytes.NewReader(field))})
+	dz, err := report.NewFieldReader(dec)
+	if err != nil {
+		t.Fatalf("NewFieldReader: %v", err)
+	}
+	defer dz.Close()
+
+	out, err := io.ReadAll(dz)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	return out
+}
+
+// coreDumpField locates the CoreDump header in report and returns the
+// continuation lines following it, up to (but not including) the next
+// field. It finds the header's terminating newline dynamically rather
+// than assuming a fixed header text, since the header's value (e.g.
+// "base64") is not part of this test's concern.
+func coreDumpField(t *testing.T, report []byte) []byte {
+	t.Helper()
+
+	idx := bytes.Index(report, []byte("CoreDump:"))
+	if idx < 0 {
+		t.Fatal("CoreDump header not found in written report")
+	}
+	nl := bytes.IndexByte(report[idx:], '\n')
+	if nl < 0 {
+		t.Fatal("CoreDump header has no terminating newline")
+	}
+	return report[idx+nl+1:]
+}
+
+func TestParallelReaderMatchesSequential(t *testing.T) {
+	core := bytes.Repeat([]byte{0x00, 0x01, 0xfe, 0xff}, 300000)
+
+	var rep bytes.Buffer
+	if err := NewWriter(&rep, 6).WriteReport(nil, bytes.NewReader(core)); err != nil {
+		t.Fatalf("WriteReport: %v", err)
+	}
+	// apport sorts fields alphabetically, so CoreDump is rarely the last
+	// field in a real report; append a trailing field to make sure both
+	// readers stop at the field boundary instead of reading into it.
+	rep.WriteString("ZZZTrailing: should not be consumed\n")
+
+	field := coreDumpField(t, rep.Bytes())
+
+	want := sequentialDecode(t, field)
+	if !bytes.Equal(want, core) {
+		t.Fatalf("sequential baseline itself is wrong: got %d bytes, want %d", len(want), len(core))
+	}
+
+	for _, opts := range []ParallelOptions{
+		{},
+		{ChunkSize: 8, Workers: 1, BufferSize: 1},
+		{ChunkSize: 37, Workers: 5, BufferSize: 2},
+		{ChunkSize: 256 << 10, Workers: 8, BufferSize: 4},
+	} {
+		t.Run(fmt.Sprintf("%+v", opts), func(t *testing.T) {
+			rc, err := NewParallelReader(bytes.NewReader(field), opts)
+			if err != nil {
+				t.Fatalf("NewParallelReader: %v", err)
+			}
+			defer rc.Close()
+
+			got, err := io.ReadAll(rc)
+			if err != nil {
+				t.Fatalf("ReadAll: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("parallel decode differs from sequential: got %d bytes, want %d", len(got), len(want))
+			}
+		})
+	}
+}
+
+func TestParallelReaderCloseUnblocksEarly(t *testing.T) {
+	core := bytes.Repeat([]byte("x"), 5_000_000)
+
+	var rep bytes.Buffer
+	if err := NewWriter(&rep, 1).WriteReport(nil, bytes.NewReader(core)); err != nil {
+		t.Fatalf("WriteReport: %v", err)
+	}
+	field := coreDumpField(t, rep.Bytes())
+
+	rc, err := NewParallelReader(bytes.NewReader(field), ParallelOptions{ChunkSize: 64, Workers: 4, BufferSize: 1})
+	if err != nil {
+		t.Fatalf("NewParallelReader: %v", err)
+	}
+
+	if _, err := rc.Read(make([]byte, 16)); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- rc.Close() }()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not return; a pipeline goroutine is likely stuck in pw.Write")
+	}
+}
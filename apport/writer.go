@@ -0,0 +1,157 @@
+package apport
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/gzip"
+)
+
+// lineWidth is the number of base64 characters per CoreDump continuation
+// line, matching the width used by apport itself and by CoreReaderAt's
+// reader.
+const lineWidth = 76
+
+// Writer is the inverse of CoreReaderAt/report.Parser: it emits a valid
+// apport problem report, gzip-compressing and base64-encoding a raw core
+// dump under CoreDump: and writing every other field as a plain
+// "Key: Value" line, or as a space-prefixed continuation block (matching
+// report.Parser.dispatchPlain) when its value contains a newline - e.g. a
+// redacted ProcEnviron or a truncated ProcMaps fed back in via -repack.
+type Writer struct {
+	w     io.Writer
+	level int
+}
+
+// NewWriter creates a Writer that emits a problem report to w, gzip-
+// compressing the CoreDump field at level (see compress/gzip's level
+// constants, e.g. gzip.DefaultCompression or gzip.BestCompression).
+func NewWriter(w io.Writer, level int) *Writer {
+	return &Writer{w: w, level: level}
+}
+
+// WriteReport writes fields followed by a CoreDump field built from core
+// to w. fields must not contain a "CoreDump" entry, and no field name may
+// contain a newline; core is gzip-compressed and base64-encoded as it is
+// copied, so it is never held in memory in full.
+func (wr *Writer) WriteReport(fields map[string]string, core io.Reader) error {
+	if _, ok := fields["CoreDump"]; ok {
+		return fmt.Errorf("apport: fields must not contain CoreDump")
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		if strings.Contains(name, "\n") {
+			return fmt.Errorf("apport: field name %q contains a newline", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := wr.writeField(name, fields[name]); err != nil {
+			return err
+		}
+	}
+
+	if _, err := fmt.Fprintln(wr.w, "CoreDump: base64"); err != nil {
+		return err
+	}
+
+	lw := &lineWrapWriter{w: wr.w, width: lineWidth}
+	b64 := base64.NewEncoder(base64.StdEncoding, lw)
+
+	gz, err := gzip.NewWriterLevel(b64, wr.level)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(gz, core); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := b64.Close(); err != nil {
+		return err
+	}
+	return lw.Flush()
+}
+
+// writeField writes one field of the report. A value with no newline is
+// written as a literal "Name: value" line, matching fieldName's default
+// case in report.Parser. A value containing a newline is written as
+// report.Parser.dispatchPlain expects instead: a bare "Name:" header
+// followed by one space-prefixed continuation line per line of value, so
+// it round-trips exactly - the "Name: value" form can't represent a
+// newline at all, since everything after the first one would read back
+// as a bare, colon-less line dispatchPlain never sees.
+func (wr *Writer) writeField(name, value string) error {
+	if !strings.Contains(value, "\n") {
+		_, err := fmt.Fprintf(wr.w, "%s: %s\n", name, value)
+		return err
+	}
+
+	if _, err := fmt.Fprintf(wr.w, "%s:\n", name); err != nil {
+		return err
+	}
+
+	lines := strings.Split(value, "\n")
+	if lines[len(lines)-1] == "" {
+		// value already ended in \n; every continuation line below gets
+		// its own \n regardless, so dropping this trailing empty split
+		// piece avoids writing out a spurious blank line for it.
+		lines = lines[:len(lines)-1]
+	}
+	for _, line := range lines {
+		if _, err := fmt.Fprintf(wr.w, " %s\n", line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lineWrapWriter splits a stream of bytes into width-byte chunks, each
+// written to w as a single space-prefixed, newline-terminated line -
+// apport's encoding for continuation lines.
+type lineWrapWriter struct {
+	w     io.Writer
+	width int
+	buf   []byte
+}
+
+func (lw *lineWrapWriter) Write(p []byte) (int, error) {
+	lw.buf = append(lw.buf, p...)
+	for len(lw.buf) >= lw.width {
+		if err := lw.writeLine(lw.buf[:lw.width]); err != nil {
+			return 0, err
+		}
+		lw.buf = lw.buf[lw.width:]
+	}
+	return len(p), nil
+}
+
+// Flush writes out any buffered bytes shorter than a full line. Callers
+// must call Flush once after the last Write.
+func (lw *lineWrapWriter) Flush() error {
+	if len(lw.buf) == 0 {
+		return nil
+	}
+	err := lw.writeLine(lw.buf)
+	lw.buf = nil
+	return err
+}
+
+func (lw *lineWrapWriter) writeLine(chunk []byte) error {
+	if _, err := lw.w.Write([]byte{' '}); err != nil {
+		return err
+	}
+	if _, err := lw.w.Write(chunk); err != nil {
+		return err
+	}
+	_, err := lw.w.Write([]byte{'\n'})
+	return err
+}
@@ -1,138 +1,145 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
-	"compress/gzip"
-	"compress/zlib"
-	"encoding/base64"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
-)
+	"path/filepath"
+	"strings"
 
-var problemReport = flag.String("path", "", "Path to problem report")
+	"github.com/a-palchikov/apport-core-unpacker/apport"
+	"github.com/a-palchikov/apport-core-unpacker/report"
+	"github.com/klauspost/compress/gzip"
+)
 
-// reader is an io.Reader that can read base64 compressed data stored as lines
-// prefixed with a whitespace
-type reader struct {
-	r    *bufio.Reader
-	data []byte
-}
+var (
+	problemReport = flag.String("path", "", "Path to problem report")
+	fields        = flag.String("fields", "CoreDump", "Comma-separated list of report fields to extract")
+	outDir        = flag.String("outdir", ".", "Directory to save extracted fields to")
 
-// formatReader is a io.Reader that determines the compression format used
-// by the problem report file
-type formatReader struct {
-	r *bufio.Reader
-	z io.ReadCloser
-}
+	repack   = flag.Bool("repack", false, "Repack a core dump plus metadata fields into an apport report")
+	corePath = flag.String("core", "CoreDump.core", `Path to the raw core dump to repack (with -repack)`)
+	metaDir  = flag.String("metadata", "", `Directory of <Field>.txt files to repack (with -repack), as written by -fields`)
+	outPath  = flag.String("out", "repacked.crash", "Output path for the repacked report (with -repack)")
+	level    = flag.Int("level", gzip.DefaultCompression, "gzip compression level to use when repacking")
+)
 
 func init() {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
 		flag.PrintDefaults()
-		fmt.Fprintf(os.Stderr, "Extracted core dump is saved as CoreDump.core.\n")
+		fmt.Fprintf(os.Stderr, "Each extracted field is saved as <outdir>/<Field>.core (CoreDump) or <outdir>/<Field>.txt.\n")
 	}
 }
 
 func main() {
 	flag.Parse()
 
+	if *repack {
+		if err := runRepack(); err != nil {
+			log.Fatalf("unable to repack: %s", err)
+		}
+		return
+	}
+
 	if *problemReport == "" {
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	var f *os.File
-	var err error
-	var r io.ReadCloser
-	var data []byte
-
-	if f, err = os.OpenFile(*problemReport, os.O_RDONLY, 0666); err != nil {
+	f, err := os.OpenFile(*problemReport, os.O_RDONLY, 0666)
+	if err != nil {
 		log.Fatalf(`unable to open "%s": %s`, *problemReport, err)
 	}
+	defer f.Close()
 
-	rdr := bufio.NewReader(f)
-
-	// Skip everything to the line prefixed with `CoreDump:`
-	for {
-		if data, err = rdr.ReadBytes('\n'); err != nil {
-			break
-		}
-		if bytes.HasPrefix(data, []byte("CoreDump:")) {
-			break
+	p := report.NewParser()
+	for _, name := range strings.Split(*fields, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			p.Handle(name, saveField(*outDir))
 		}
 	}
 
-	if err != nil {
-		log.Fatal("unable to read: %s", err)
+	if err := p.Parse(f); err != nil {
+		log.Fatalf("unable to parse %q: %s", *problemReport, err)
 	}
+}
 
-	if r, err = newReader(base64.NewDecoder(base64.StdEncoding, &reader{r: rdr})); err != nil {
-		log.Fatalf("unable to create reader: %s", err)
+// runRepack reads the raw core dump at *corePath and the metadata fields
+// under *metaDir (if any), and writes them back out as an apport report
+// at *outPath, suitable for re-submission.
+func runRepack() error {
+	core, err := os.Open(*corePath)
+	if err != nil {
+		return fmt.Errorf("unable to open core %q: %w", *corePath, err)
 	}
-	defer r.Close()
+	defer core.Close()
 
-	if out, err := os.Create("CoreDump.core"); err != nil {
-		log.Fatalf("unable to create output file: %s", err)
-	} else {
-		if _, err = io.Copy(out, r); err != nil {
-			log.Fatalf("unable to save file: %s", err)
+	var metaFields map[string]string
+	if *metaDir != "" {
+		if metaFields, err = readMetadata(*metaDir); err != nil {
+			return fmt.Errorf("unable to read metadata %q: %w", *metaDir, err)
 		}
 	}
-}
 
-func (r *reader) Read(b []byte) (n int, err error) {
-	if len(r.data) > 0 {
-		// Read from the scratch buffer
-		n = copy(b, r.data)
-		r.data = r.data[n:]
-		return
-	}
-	if len(b) == 0 {
-		return 0, nil
+	out, err := os.Create(*outPath)
+	if err != nil {
+		return fmt.Errorf("unable to create %q: %w", *outPath, err)
 	}
+	defer out.Close()
 
-	if r.data, err = r.r.ReadBytes('\n'); err != nil {
-		return 0, err
-	}
+	return apport.NewWriter(out, *level).WriteReport(metaFields, core)
+}
 
-	if len(r.data) == 0 || r.data[0] != ' ' {
-		return 0, io.EOF
+// readMetadata reads every <Field>.txt file directly under dir into a
+// field map, for -repack mode - the inverse of saveField's non-core
+// output. A "Key: Value"-per-line file can't represent a multi-line
+// field (a redacted ProcEnviron, a truncated ProcMaps) at all, so
+// -repack takes the same <Field>.txt files -fields already produces
+// instead: each one is read whole, embedded newlines and all, and
+// apport.Writer takes care of re-encoding it correctly.
+func readMetadata(dir string) (map[string]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
 	}
 
-	n = copy(b, r.data[1:])
-	r.data = r.data[n+1:]
-
-	return n, nil
-}
+	const ext = ".txt"
+	fields := make(map[string]string)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ext) {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), ext)
 
-func newReader(r io.Reader) (io.ReadCloser, error) {
-	return &formatReader{r: bufio.NewReader(r)}, nil
+		b, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("reading %q: %w", entry.Name(), err)
+		}
+		fields[name] = string(b)
+	}
+	return fields, nil
 }
 
-func (r *formatReader) Read(b []byte) (n int, err error) {
-	if r.z == nil {
-		var b []byte
-
-		b, err = r.r.Peek(3)
-
-		if bytes.Equal(b, []byte{0x1f, 0x8b, 0x8}) {
-			// gzip
-			r.z, err = gzip.NewReader(r.r)
-		} else {
-			// legacy zlib-only format
-			r.z, err = zlib.NewReader(r.r)
+// saveField returns a report.Handler that saves a field's decoded bytes
+// under outDir, named after the field: CoreDump.core for the core dump,
+// <Field>.txt for everything else.
+func saveField(outDir string) report.Handler {
+	return func(name string, r io.Reader) error {
+		ext := ".txt"
+		if name == "CoreDump" {
+			ext = ".core"
 		}
+
+		out, err := os.Create(filepath.Join(outDir, name+ext))
 		if err != nil {
-			return 0, err
+			return fmt.Errorf("unable to create output file: %w", err)
 		}
-	}
-	return r.z.Read(b)
-}
+		defer out.Close()
 
-func (r *formatReader) Close() error {
-	return r.z.Close()
+		_, err = io.Copy(out, r)
+		return err
+	}
 }
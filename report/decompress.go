@@ -0,0 +1,202 @@
+package report
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// formatReader is an io.Reader that sniffs the compression format used by
+// a report field on first Read and dispatches to the matching
+// Decompressor from the registry.
+type formatReader struct {
+	r io.Reader
+	z io.ReadCloser
+}
+
+func newReader(r io.Reader) (io.ReadCloser, error) {
+	return &formatReader{r: r}, nil
+}
+
+// NewFieldReader wraps r, the base64-decoded bytes of a single report
+// field, in a reader that auto-detects and undoes whatever compression
+// format the field was written with. Parser uses this internally to
+// dispatch each field to its Handler; it is also exported for callers
+// that need to decode a field on their own, such as a random-access
+// reader that only decompresses part of a field.
+func NewFieldReader(r io.Reader) (io.ReadCloser, error) {
+	return newReader(r)
+}
+
+func (r *formatReader) Read(b []byte) (n int, err error) {
+	if r.z == nil {
+		var name string
+		var br io.Reader
+		if name, br, err = DetectCompression(r.r); err != nil {
+			return 0, err
+		}
+		if r.z, err = lookup(name)(br); err != nil {
+			return 0, err
+		}
+	}
+	return r.z.Read(b)
+}
+
+func (r *formatReader) Close() error {
+	if r.z == nil {
+		return nil
+	}
+	return r.z.Close()
+}
+
+// Decompressor builds a decompressing io.ReadCloser around a raw,
+// still-compressed stream.
+type Decompressor func(r io.Reader) (io.ReadCloser, error)
+
+// registration pairs a named Decompressor with the magic bytes that
+// identify its format.
+type registration struct {
+	name    string
+	magic   []byte
+	factory Decompressor
+}
+
+// registry holds every known format, checked in registration order. "raw"
+// is registered last with an empty magic so it always matches and acts as
+// the fallback for uncompressed input.
+var registry []registration
+
+// Register adds a Decompressor to the registry under name, matched by its
+// leading magic bytes. Callers wanting to add support for a new format can
+// call this from an init() func; magic should be as long as needed to
+// distinguish the format unambiguously, since entries are matched with
+// bytes.HasPrefix in registration order.
+func Register(name string, magic []byte, factory Decompressor) {
+	registry = append(registry, registration{name: name, magic: magic, factory: factory})
+}
+
+func init() {
+	Register("zstd", []byte{0x28, 0xb5, 0x2f, 0xfd}, newZstdReader)
+	Register("bzip2", []byte("BZh"), newBzip2Reader)
+	Register("gzip", []byte{0x1f, 0x8b, 0x08}, newGzipReader)
+	Register("zlib", []byte{0x78}, newZlibReader)
+	Register("raw", nil, newRawReader)
+}
+
+// DetectCompression peeks at the leading bytes of r to identify the
+// compression format in use, without consuming them. It returns the
+// detected format name and a reader that will replay the peeked bytes
+// followed by the rest of r.
+func DetectCompression(r io.Reader) (string, io.Reader, error) {
+	br := bufio.NewReader(r)
+
+	n := 0
+	for _, reg := range registry {
+		if len(reg.magic) > n {
+			n = len(reg.magic)
+		}
+	}
+
+	magic, err := br.Peek(n)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return "", br, err
+	}
+
+	for _, reg := range registry {
+		if bytes.HasPrefix(magic, reg.magic) {
+			return reg.name, br, nil
+		}
+	}
+	return "raw", br, nil
+}
+
+// lookup returns the Decompressor registered under name, falling back to
+// newRawReader if name is unknown.
+func lookup(name string) Decompressor {
+	for _, reg := range registry {
+		if reg.name == name {
+			return reg.factory
+		}
+	}
+	return newRawReader
+}
+
+func newGzipReader(r io.Reader) (io.ReadCloser, error) {
+	if rc, ok := newExternalReader(r, "pigz", "APPORT_UNPACKER_DISABLE_PIGZ", "-d", "-c"); ok {
+		return rc, nil
+	}
+	return gzip.NewReader(r)
+}
+
+func newZlibReader(r io.Reader) (io.ReadCloser, error) {
+	return zlib.NewReader(r)
+}
+
+func newBzip2Reader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(bzip2.NewReader(r)), nil
+}
+
+func newZstdReader(r io.Reader) (io.ReadCloser, error) {
+	if rc, ok := newExternalReader(r, "unzstd", "APPORT_UNPACKER_DISABLE_UNZSTD", "-c"); ok {
+		return rc, nil
+	}
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return zr.IOReadCloser(), nil
+}
+
+func newRawReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(r), nil
+}
+
+// newExternalReader attempts to decompress r by shelling out to name (e.g.
+// "pigz" or "unzstd"), which can decompress in parallel and is typically
+// faster than the in-process decompressor on large cores. It reports
+// ok=false when name is unavailable on PATH or disabled via disableEnv, so
+// the caller can fall back to its own Decompressor.
+func newExternalReader(r io.Reader, name, disableEnv string, args ...string) (io.ReadCloser, bool) {
+	if os.Getenv(disableEnv) != "" {
+		return nil, false
+	}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return nil, false
+	}
+
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = r
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, false
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, false
+	}
+	return &externalProcessReader{stdout: stdout, cmd: cmd}, true
+}
+
+// externalProcessReader adapts the stdout of a decompression subprocess to
+// an io.ReadCloser, waiting for the process to exit on Close.
+type externalProcessReader struct {
+	stdout io.ReadCloser
+	cmd    *exec.Cmd
+}
+
+func (r *externalProcessReader) Read(b []byte) (int, error) {
+	return r.stdout.Read(b)
+}
+
+func (r *externalProcessReader) Close() error {
+	r.stdout.Close()
+	return r.cmd.Wait()
+}
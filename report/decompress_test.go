@@ -0,0 +1,170 @@
+package report
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// bzip2Fixture is "the quick brown fox jumps over the lazy dog\n" compressed
+// with bzip2 -9, captured once offline: compress/bzip2 only ships a reader,
+// so there is no in-process way to produce bzip2 test input.
+var bzip2Fixture = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x31, 0x57, 0xe9, 0x94, 0x00, 0x00,
+	0x12, 0x51, 0x80, 0x00, 0x10, 0x40, 0x00, 0x3f, 0xff, 0xff, 0xf0, 0x20, 0x00, 0x22, 0xa7, 0xa6,
+	0x88, 0x30, 0x9a, 0x68, 0x6d, 0x1b, 0x50, 0x51, 0xa1, 0xa0, 0x00, 0x00, 0x39, 0x90, 0xf0, 0x45,
+	0x09, 0x3d, 0x85, 0x4a, 0xac, 0x56, 0xdb, 0x0c, 0x53, 0xf8, 0x9a, 0x2c, 0x71, 0x4c, 0x1f, 0x77,
+	0x53, 0xb8, 0x14, 0xdb, 0x39, 0xd0, 0xbb, 0x92, 0x29, 0xc2, 0x84, 0x81, 0x8a, 0xbf, 0x4c, 0xa0,
+}
+
+const bzip2FixturePayload = "the quick brown fox jumps over the lazy dog\n"
+
+func mustGzip(t *testing.T, payload []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write(payload); err != nil {
+		t.Fatalf("gzip.Write: %v", err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatalf("gzip.Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func mustZlib(t *testing.T, payload []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(payload); err != nil {
+		t.Fatalf("zlib.Write: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("zlib.Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func mustZstd(t *testing.T, payload []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("zstd.NewWriter: %v", err)
+	}
+	if _, err := zw.Write(payload); err != nil {
+		t.Fatalf("zstd.Write: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("zstd.Close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// TestDetectAndDecompressRoundTrip exercises DetectCompression and lookup
+// together for every registered format, the same pairing NewFieldReader
+// uses internally.
+func TestDetectAndDecompressRoundTrip(t *testing.T) {
+	payload := []byte(bzip2FixturePayload)
+
+	tests := []struct {
+		name    string
+		want    string
+		encoded []byte
+	}{
+		{name: "zstd", want: "zstd", encoded: mustZstd(t, payload)},
+		{name: "bzip2", want: "bzip2", encoded: bzip2Fixture},
+		{name: "gzip", want: "gzip", encoded: mustGzip(t, payload)},
+		{name: "zlib", want: "zlib", encoded: mustZlib(t, payload)},
+		{name: "raw", want: "raw", encoded: payload},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("APPORT_UNPACKER_DISABLE_PIGZ", "1")
+			t.Setenv("APPORT_UNPACKER_DISABLE_UNZSTD", "1")
+
+			name, br, err := DetectCompression(bytes.NewReader(tt.encoded))
+			if err != nil {
+				t.Fatalf("DetectCompression: %v", err)
+			}
+			if name != tt.want {
+				t.Fatalf("DetectCompression name = %q, want %q", name, tt.want)
+			}
+
+			dz, err := lookup(name)(br)
+			if err != nil {
+				t.Fatalf("lookup(%q): %v", name, err)
+			}
+			defer dz.Close()
+
+			got, err := io.ReadAll(dz)
+			if err != nil {
+				t.Fatalf("ReadAll: %v", err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Fatalf("decompressed %q, want %q", got, payload)
+			}
+		})
+	}
+}
+
+// TestNewFieldReaderRoundTrip checks the exported NewFieldReader entry
+// point end to end, rather than DetectCompression/lookup individually.
+func TestNewFieldReaderRoundTrip(t *testing.T) {
+	payload := []byte(bzip2FixturePayload)
+
+	r, err := NewFieldReader(bytes.NewReader(mustGzip(t, payload)))
+	if err != nil {
+		t.Fatalf("NewFieldReader: %v", err)
+	}
+	defer r.Close()
+
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("decompressed %q, want %q", got, payload)
+	}
+}
+
+// TestNewGzipReaderDisableEnvFallsBackToInProcess checks that setting
+// APPORT_UNPACKER_DISABLE_PIGZ skips the pigz subprocess path even when
+// pigz is on PATH, falling back to compress/gzip.
+func TestNewGzipReaderDisableEnvFallsBackToInProcess(t *testing.T) {
+	t.Setenv("APPORT_UNPACKER_DISABLE_PIGZ", "1")
+
+	payload := []byte(bzip2FixturePayload)
+	rc, err := newGzipReader(bytes.NewReader(mustGzip(t, payload)))
+	if err != nil {
+		t.Fatalf("newGzipReader: %v", err)
+	}
+	defer rc.Close()
+
+	if _, ok := rc.(*externalProcessReader); ok {
+		t.Fatal("newGzipReader used the pigz subprocess despite APPORT_UNPACKER_DISABLE_PIGZ")
+	}
+
+	got, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatalf("decompressed %q, want %q", got, payload)
+	}
+}
+
+// TestNewExternalReaderMissingBinaryFallsBack checks that an unresolvable
+// binary name reports ok=false so callers fall back to their own
+// Decompressor, independent of any disable env var.
+func TestNewExternalReaderMissingBinaryFallsBack(t *testing.T) {
+	_, ok := newExternalReader(bytes.NewReader(nil), "apport-unpacker-no-such-binary", "APPORT_UNPACKER_DISABLE_NONEXISTENT")
+	if ok {
+		t.Fatal("newExternalReader reported ok=true for a binary that isn't on PATH")
+	}
+}
@@ -0,0 +1,44 @@
+package report
+
+import (
+	"bufio"
+	"io"
+)
+
+// lineReader is an io.Reader that reads base64-encoded data stored as
+// lines prefixed with a single leading space, stopping at the first line
+// that isn't (or at EOF). The terminating line is left unconsumed in r so
+// the caller can keep scanning the report for the next field.
+type lineReader struct {
+	r    *bufio.Reader
+	data []byte
+}
+
+func (r *lineReader) Read(b []byte) (n int, err error) {
+	if len(r.data) > 0 {
+		// Read from the scratch buffer
+		n = copy(b, r.data)
+		r.data = r.data[n:]
+		return
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	prefix, err := r.r.Peek(1)
+	if err != nil {
+		return 0, err
+	}
+	if prefix[0] != ' ' {
+		return 0, io.EOF
+	}
+
+	if r.data, err = r.r.ReadBytes('\n'); err != nil {
+		return 0, err
+	}
+
+	n = copy(b, r.data[1:])
+	r.data = r.data[n+1:]
+
+	return n, nil
+}
@@ -0,0 +1,126 @@
+// Package report implements a streaming parser for apport's RFC822-style
+// problem report format. Fields of interest (CoreDump, ProcMaps, ...) are
+// base64-encoded and usually compressed; Parser decodes and decompresses
+// each registered field in a single pass over the report and hands the
+// raw bytes to a Handler.
+package report
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Handler processes the decoded, decompressed bytes of the report field
+// named name.
+type Handler func(name string, r io.Reader) error
+
+// Parser walks an apport problem report once, dispatching every
+// registered field to its Handler as it is encountered.
+type Parser struct {
+	handlers map[string]Handler
+}
+
+// NewParser creates a Parser with no fields registered; call Handle for
+// each field to extract before calling Parse.
+func NewParser() *Parser {
+	return &Parser{handlers: make(map[string]Handler)}
+}
+
+// Handle registers fn to process the field named name (e.g. "CoreDump",
+// "ProcMaps"). Fields without a registered handler are skipped over.
+func (p *Parser) Handle(name string, fn Handler) {
+	p.handlers[name] = fn
+}
+
+// Parse scans r for "<Field>:" headers. A header's value tells Parse how
+// its continuation lines are encoded: "base64" means base64-encoded and
+// usually compressed (e.g. CoreDump), matching apport's own convention;
+// an empty value means plain space-prefixed text (e.g. ProcMaps,
+// ProcStatus); anything else is a literal single-line value. When a
+// header names a registered field, Parse decodes it accordingly and
+// calls the field's Handler with the result before resuming the scan for
+// the next field.
+func (p *Parser) Parse(r io.Reader) error {
+	rdr := bufio.NewReader(r)
+
+	for {
+		line, rerr := rdr.ReadBytes('\n')
+		if rerr != nil && rerr != io.EOF {
+			return rerr
+		}
+
+		if name, value, ok := fieldName(line); ok {
+			if fn, ok := p.handlers[name]; ok {
+				if err := p.dispatch(rdr, name, value, fn); err != nil {
+					return err
+				}
+			}
+		}
+
+		if rerr == io.EOF {
+			return nil
+		}
+	}
+}
+
+// dispatch decodes the field named name, encoded as described by value,
+// and passes the result to fn.
+func (p *Parser) dispatch(rdr *bufio.Reader, name, value string, fn Handler) error {
+	switch value {
+	case "base64":
+		return p.dispatchBase64(rdr, name, fn)
+	case "":
+		return p.dispatchPlain(rdr, name, fn)
+	default:
+		if err := fn(name, strings.NewReader(value)); err != nil {
+			return fmt.Errorf("field %q: %w", name, err)
+		}
+		return nil
+	}
+}
+
+// dispatchBase64 decodes the continuation lines following a "base64"
+// field header and passes the decompressed result to fn.
+func (p *Parser) dispatchBase64(rdr *bufio.Reader, name string, fn Handler) error {
+	fr, err := newReader(base64.NewDecoder(base64.StdEncoding, &lineReader{r: rdr}))
+	if err != nil {
+		return fmt.Errorf("field %q: %w", name, err)
+	}
+
+	if err := fn(name, fr); err != nil {
+		fr.Close()
+		return fmt.Errorf("field %q: %w", name, err)
+	}
+	if err := fr.Close(); err != nil {
+		return fmt.Errorf("field %q: %w", name, err)
+	}
+	return nil
+}
+
+// dispatchPlain passes the continuation lines following a plain-text
+// field header (ProcMaps, ProcStatus, Stacktrace, ...) to fn verbatim,
+// with no base64 decoding or decompression.
+func (p *Parser) dispatchPlain(rdr *bufio.Reader, name string, fn Handler) error {
+	if err := fn(name, &lineReader{r: rdr}); err != nil {
+		return fmt.Errorf("field %q: %w", name, err)
+	}
+	return nil
+}
+
+// fieldName reports whether line is a top-level "<Name>: value" header,
+// as opposed to a space-prefixed continuation line, and returns the name
+// and the trimmed value following the colon.
+func fieldName(line []byte) (name, value string, ok bool) {
+	if len(line) == 0 || line[0] == ' ' {
+		return "", "", false
+	}
+	i := bytes.IndexByte(line, ':')
+	if i <= 0 {
+		return "", "", false
+	}
+	return string(line[:i]), string(bytes.TrimSpace(line[i+1:])), true
+}
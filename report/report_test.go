@@ -0,0 +1,81 @@
+package report
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestParserDispatchPlainAndLiteral checks the two non-base64 paths in
+// dispatch: a plain space-prefixed multi-line field (ProcMaps-style, no
+// "base64" marker) and a literal single-line field (fieldName's default
+// case). It also checks that each field's Handler only sees its own lines,
+// not its neighbor's.
+func TestParserDispatchPlainAndLiteral(t *testing.T) {
+	rep := "ProblemType: Crash\n" +
+		"ProcMaps:\n" +
+		" 00400000-00401000 r-xp 00000000 00:00 0 foo\n" +
+		" 00600000-00601000 rw-p 00000000 00:00 0 foo\n" +
+		"Package: foo 1.2.3\n"
+
+	var problemType, pkg string
+	var procMaps []byte
+
+	p := NewParser()
+	p.Handle("ProblemType", func(name string, r io.Reader) error {
+		b, err := io.ReadAll(r)
+		problemType = string(b)
+		return err
+	})
+	p.Handle("ProcMaps", func(name string, r io.Reader) error {
+		var err error
+		procMaps, err = io.ReadAll(r)
+		return err
+	})
+	p.Handle("Package", func(name string, r io.Reader) error {
+		b, err := io.ReadAll(r)
+		pkg = string(b)
+		return err
+	})
+
+	if err := p.Parse(strings.NewReader(rep)); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	if problemType != "Crash" {
+		t.Fatalf("ProblemType handler got %q, want %q", problemType, "Crash")
+	}
+	wantProcMaps := "00400000-00401000 r-xp 00000000 00:00 0 foo\n" +
+		"00600000-00601000 rw-p 00000000 00:00 0 foo\n"
+	if string(procMaps) != wantProcMaps {
+		t.Fatalf("ProcMaps handler got %q, want %q", procMaps, wantProcMaps)
+	}
+	if pkg != "foo 1.2.3" {
+		t.Fatalf("Package handler got %q, want %q", pkg, "foo 1.2.3")
+	}
+}
+
+// TestParserDispatchPlainFieldAtEOF checks that a plain field with no
+// trailing field after it is still delivered to its Handler in full.
+func TestParserDispatchPlainFieldAtEOF(t *testing.T) {
+	rep := "Stacktrace:\n" +
+		" #0  0x00000000 in main ()\n" +
+		" #1  0x00000000 in __libc_start_main ()\n"
+
+	var got []byte
+	p := NewParser()
+	p.Handle("Stacktrace", func(name string, r io.Reader) error {
+		var err error
+		got, err = io.ReadAll(r)
+		return err
+	})
+
+	if err := p.Parse(strings.NewReader(rep)); err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	want := "#0  0x00000000 in main ()\n#1  0x00000000 in __libc_start_main ()\n"
+	if string(got) != want {
+		t.Fatalf("Stacktrace handler got %q, want %q", got, want)
+	}
+}